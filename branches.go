@@ -0,0 +1,56 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// branchFromRef extracts the branch name from a full ref such as
+// "refs/heads/main", returning "" if ref isn't a branch ref.
+func branchFromRef(ref string) string {
+	const prefix = "refs/heads/"
+	if !strings.HasPrefix(ref, prefix) {
+		return ""
+	}
+	return strings.TrimPrefix(ref, prefix)
+}
+
+// allowedBranch reports whether branch is configured for deployment on this
+// repository. An empty Branches list keeps the historical default of only
+// deploying master.
+func allowedBranch(rc RepoConfig, branch string) bool {
+	if len(rc.Branches) == 0 {
+		return branch == "master"
+	}
+
+	for _, b := range rc.Branches {
+		if b == branch {
+			return true
+		}
+	}
+	return false
+}
+
+// checkoutDir returns the directory a branch of a repository is checked out
+// into, e.g. HookPath/group/project/main.
+func checkoutDir(repoKey, branch string) string {
+	return filepath.Join(c.HookPath, repoKey, branch)
+}
+
+// prNumberPattern pulls the pull/merge request number out of a HeadRef such
+// as "refs/pull/123/head" or "refs/merge-requests/123/head".
+var prNumberPattern = regexp.MustCompile(`\d+`)
+
+// prCheckoutDir returns the directory a pull/merge request identified by
+// headRef is checked out into, e.g. HookPath/group/project/pr-123. This is
+// always distinct from checkoutDir's branch directory, so building an
+// unreviewed pull/merge request head can never overwrite a branch's
+// production checkout.
+func prCheckoutDir(repoKey, headRef string) string {
+	n := prNumberPattern.FindString(headRef)
+	if n == "" {
+		n = "unknown"
+	}
+	return filepath.Join(c.HookPath, repoKey, "pr-"+n)
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestBranchFromRef(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"refs/heads/main", "main"},
+		{"refs/heads/feature/x", "feature/x"},
+		{"refs/tags/v1.0.0", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := branchFromRef(tt.ref); got != tt.want {
+			t.Errorf("branchFromRef(%q) = %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestAllowedBranch(t *testing.T) {
+	tests := []struct {
+		name   string
+		rc     RepoConfig
+		branch string
+		want   bool
+	}{
+		{"default to master when unconfigured", RepoConfig{}, "master", true},
+		{"default rejects non-master when unconfigured", RepoConfig{}, "develop", false},
+		{"configured branch allowed", RepoConfig{Branches: []string{"main", "develop"}}, "develop", true},
+		{"unconfigured branch rejected", RepoConfig{Branches: []string{"main"}}, "develop", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := allowedBranch(tt.rc, tt.branch); got != tt.want {
+				t.Errorf("allowedBranch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Changes is implemented by webhook payloads that know which commits are
+// being compared, so the changed files between them can be computed.
+// A commit the payload has no SHA for (e.g. the "before" of a push that
+// creates a new branch) comes back empty or all zeros, and changedFiles
+// then reports that it can't tell which files changed.
+type Changes interface {
+	Changes() (base, head string)
+}
+
+// RefChange is a single ref update carried by a webhook delivery.
+type RefChange struct {
+	Ref              string
+	BaseSHA, HeadSHA string
+	// HeadRef, if set, is a ref fetchable from "origin" that resolves to
+	// HeadSHA, for changes whose head commit may not already be reachable
+	// locally (e.g. a pull request from a fork).
+	HeadRef string
+}
+
+// MultiRef is implemented by webhook payloads that can carry more than one
+// ref update in a single delivery (e.g. Bitbucket Server's repo:refs_changed,
+// which can bundle several branch/tag updates into one payload). Callers
+// should prefer Refs() over Ref()/Changes() when a payload implements it, so
+// no ref update in the delivery is silently dropped.
+type MultiRef interface {
+	Refs() []RefChange
+}
+
+// PullRequestRef is implemented by pull/merge request payloads whose head
+// commit may live on a fork or otherwise isn't already reachable from
+// "origin" by SHA, so it must be fetched by ref (e.g. GitHub's
+// "refs/pull/<n>/head") before it can be diffed or built.
+type PullRequestRef interface {
+	HeadRef() string
+}
+
+// eventType returns the header a provider uses to identify the kind of
+// event a webhook carries (push vs. pull/merge request).
+func eventType(p Provider, r *http.Request) string {
+	switch p {
+	case ProviderGitHub:
+		return r.Header.Get("X-GitHub-Event")
+	case ProviderGitLab:
+		return r.Header.Get("X-Gitlab-Event")
+	case ProviderBitbucket:
+		return r.Header.Get("X-Event-Key")
+	default:
+		return ""
+	}
+}
+
+// GitHubPushWebhook is a GitHub "push" event, stripped to what we need.
+type GitHubPushWebhook struct {
+	RefName string `json:"ref"`
+	Before  string `json:"before"`
+	After   string `json:"after"`
+}
+
+// Ref returns the ref of the change, e.g. "refs/heads/main"
+func (g GitHubPushWebhook) Ref() string { return g.RefName }
+
+// Changes returns the before/after commits of the push.
+func (g GitHubPushWebhook) Changes() (string, string) { return g.Before, g.After }
+
+// GitHubPullRequestWebhook is a GitHub "pull_request" event, stripped to
+// what we need.
+type GitHubPullRequestWebhook struct {
+	Number      int `json:"number"`
+	PullRequest struct {
+		Base struct {
+			Ref string `json:"ref"`
+			SHA string `json:"sha"`
+		} `json:"base"`
+		Head struct {
+			SHA string `json:"sha"`
+		} `json:"head"`
+	} `json:"pull_request"`
+}
+
+// Ref returns the PR's base ref, e.g. "refs/heads/main"
+func (g GitHubPullRequestWebhook) Ref() string { return "refs/heads/" + g.PullRequest.Base.Ref }
+
+// Changes returns the PR's base and head commits.
+func (g GitHubPullRequestWebhook) Changes() (string, string) {
+	return g.PullRequest.Base.SHA, g.PullRequest.Head.SHA
+}
+
+// HeadRef returns a ref GitHub always maintains for a pull request's head,
+// regardless of whether it lives on a fork.
+func (g GitHubPullRequestWebhook) HeadRef() string {
+	return fmt.Sprintf("refs/pull/%d/head", g.Number)
+}
+
+// GitLabMergeRequestWebhook is a GitLab "Merge Request Hook" event, stripped
+// to what we need.
+type GitLabMergeRequestWebhook struct {
+	ObjectAttributes struct {
+		IID          int    `json:"iid"`
+		TargetBranch string `json:"target_branch"`
+		DiffRefs     struct {
+			BaseSha string `json:"base_sha"`
+			HeadSha string `json:"head_sha"`
+		} `json:"diff_refs"`
+	} `json:"object_attributes"`
+}
+
+// Ref returns the merge request's target ref, e.g. "refs/heads/main"
+func (g GitLabMergeRequestWebhook) Ref() string {
+	return "refs/heads/" + g.ObjectAttributes.TargetBranch
+}
+
+// Changes returns the merge request's base and head commits.
+func (g GitLabMergeRequestWebhook) Changes() (string, string) {
+	return g.ObjectAttributes.DiffRefs.BaseSha, g.ObjectAttributes.DiffRefs.HeadSha
+}
+
+// HeadRef returns a ref GitLab always maintains for a merge request's head,
+// regardless of whether it lives on a fork.
+func (g GitLabMergeRequestWebhook) HeadRef() string {
+	return fmt.Sprintf("refs/merge-requests/%d/head", g.ObjectAttributes.IID)
+}
+
+// BitbucketPullRequestWebhook handles Bitbucket Server's pr:opened and
+// pr:merged events, stripped to what we need.
+type BitbucketPullRequestWebhook struct {
+	PullRequest struct {
+		ID      int `json:"id"`
+		FromRef struct {
+			LatestCommit string `json:"latestCommit"`
+		} `json:"fromRef"`
+		ToRef struct {
+			ID           string `json:"id"`
+			LatestCommit string `json:"latestCommit"`
+		} `json:"toRef"`
+	} `json:"pullRequest"`
+}
+
+// Ref returns the pull request's target ref, e.g. "refs/heads/main"
+func (b BitbucketPullRequestWebhook) Ref() string { return b.PullRequest.ToRef.ID }
+
+// Changes returns the pull request's target and source commits.
+func (b BitbucketPullRequestWebhook) Changes() (string, string) {
+	return b.PullRequest.ToRef.LatestCommit, b.PullRequest.FromRef.LatestCommit
+}
+
+// HeadRef returns a ref Bitbucket Server always maintains for a pull
+// request's source commit, regardless of whether it lives on a fork.
+func (b BitbucketPullRequestWebhook) HeadRef() string {
+	return fmt.Sprintf("refs/pull-requests/%d/from", b.PullRequest.ID)
+}
@@ -0,0 +1,43 @@
+// Package gitbackend abstracts the git operations hooker needs behind a
+// small interface, so the underlying implementation can be swapped through
+// configuration without touching the dispatcher or update logic.
+package gitbackend
+
+// Backend opens repositories. Implementations choose how to actually talk
+// to git: a pure-Go library, or the system git binary.
+type Backend interface {
+	Open(path string) (Repository, error)
+
+	// Clone clones url into path, which must not already exist. It is used
+	// to provision a checkout directory on demand, e.g. the first time a
+	// pull/merge request is built.
+	Clone(url, path string) error
+}
+
+// Repository is a single opened git repository, checked out at a known
+// path on disk with an "origin" remote already configured.
+type Repository interface {
+	// Fetch fetches branch from the "origin" remote.
+	Fetch(branch string) error
+
+	// HardResetToRemote hard-resets the working tree and local branch ref
+	// to match origin/<branch>, equivalent to `git reset --hard
+	// origin/<branch>`.
+	HardResetToRemote(branch string) error
+
+	// FetchRef fetches an arbitrary ref from the "origin" remote, such as a
+	// pull request's head (which may live on a fork and have no local
+	// tracking branch), and returns the commit SHA it resolved to.
+	FetchRef(ref string) (string, error)
+
+	// ResetToSHA hard-resets the working tree to the given commit, which
+	// must already be present in the repository (e.g. via Fetch or
+	// FetchRef).
+	ResetToSHA(sha string) error
+
+	// CurrentSHA returns the commit SHA HEAD now points to.
+	CurrentSHA() (string, error)
+
+	// RemoteURL returns the URL configured for the "origin" remote.
+	RemoteURL() (string, error)
+}
@@ -0,0 +1,125 @@
+package gitbackend
+
+import (
+	"fmt"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// GoGit implements Backend using go-git, a pure-Go git implementation that
+// needs no cgo and cross-compiles cleanly.
+type GoGit struct{}
+
+type goGitRepository struct {
+	repo *git.Repository
+}
+
+// Open opens the repository at path.
+func (GoGit) Open(path string) (Repository, error) {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return nil, err
+	}
+	return &goGitRepository{repo: repo}, nil
+}
+
+// Clone clones url into path, which must not already exist.
+func (GoGit) Clone(url, path string) error {
+	_, err := git.PlainClone(path, false, &git.CloneOptions{URL: url})
+	return err
+}
+
+// Fetch fetches branch from the "origin" remote, forcing the update of the
+// local remote-tracking ref so a force-push upstream doesn't leave it stuck
+// behind a non-fast-forward error on every subsequent fetch.
+func (r *goGitRepository) Fetch(branch string) error {
+	refSpec := config.RefSpec("+refs/heads/" + branch + ":refs/remotes/origin/" + branch)
+
+	err := r.repo.Fetch(&git.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{refSpec},
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return err
+	}
+	return nil
+}
+
+// HardResetToRemote hard-resets the working tree and local branch ref to
+// match origin/<branch>.
+func (r *goGitRepository) HardResetToRemote(branch string) error {
+	remoteRef, err := r.repo.Reference(plumbing.NewRemoteReferenceName("origin", branch), true)
+	if err != nil {
+		return err
+	}
+
+	wt, err := r.repo.Worktree()
+	if err != nil {
+		return err
+	}
+
+	if err := wt.Reset(&git.ResetOptions{Commit: remoteRef.Hash(), Mode: git.HardReset}); err != nil {
+		return err
+	}
+
+	localRef := plumbing.NewHashReference(plumbing.NewBranchReferenceName(branch), remoteRef.Hash())
+	return r.repo.Storer.SetReference(localRef)
+}
+
+// fetchedRefName is where FetchRef parks whatever it fetches, since the ref
+// may not correspond to a normal local branch.
+const fetchedRefName = "refs/hooker/fetched-head"
+
+// FetchRef fetches an arbitrary ref from the "origin" remote and returns the
+// commit SHA it resolved to.
+func (r *goGitRepository) FetchRef(ref string) (string, error) {
+	refSpec := config.RefSpec("+" + ref + ":" + fetchedRefName)
+
+	err := r.repo.Fetch(&git.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{refSpec},
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return "", err
+	}
+
+	fetched, err := r.repo.Reference(plumbing.ReferenceName(fetchedRefName), true)
+	if err != nil {
+		return "", err
+	}
+	return fetched.Hash().String(), nil
+}
+
+// ResetToSHA hard-resets the working tree to the given commit.
+func (r *goGitRepository) ResetToSHA(sha string) error {
+	wt, err := r.repo.Worktree()
+	if err != nil {
+		return err
+	}
+	return wt.Reset(&git.ResetOptions{Commit: plumbing.NewHash(sha), Mode: git.HardReset})
+}
+
+// CurrentSHA returns the commit SHA HEAD now points to.
+func (r *goGitRepository) CurrentSHA() (string, error) {
+	ref, err := r.repo.Head()
+	if err != nil {
+		return "", err
+	}
+	return ref.Hash().String(), nil
+}
+
+// RemoteURL returns the URL configured for the "origin" remote.
+func (r *goGitRepository) RemoteURL() (string, error) {
+	remote, err := r.repo.Remote("origin")
+	if err != nil {
+		return "", err
+	}
+
+	urls := remote.Config().URLs
+	if len(urls) == 0 {
+		return "", fmt.Errorf("origin remote has no URL configured")
+	}
+	return urls[0], nil
+}
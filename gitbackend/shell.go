@@ -0,0 +1,95 @@
+package gitbackend
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Shell implements Backend by shelling out to the system git binary. It
+// handles auth helpers, submodules and LFS more reliably than a pure-Go
+// implementation, at the cost of requiring git on PATH.
+type Shell struct{}
+
+type shellRepository struct {
+	path string
+}
+
+// Open opens the repository at path.
+func (Shell) Open(path string) (Repository, error) {
+	return &shellRepository{path: path}, nil
+}
+
+// Clone clones url into path, which must not already exist.
+func (Shell) Clone(url, path string) error {
+	cmd := exec.Command("git", "clone", url, path)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git clone %s %s: %s: %s", url, path, err, out)
+	}
+	return nil
+}
+
+func (r *shellRepository) run(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.path
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %s: %s", strings.Join(args, " "), err, out)
+	}
+	return string(out), nil
+}
+
+// Fetch fetches branch from the "origin" remote.
+func (r *shellRepository) Fetch(branch string) error {
+	_, err := r.run("fetch", "origin", branch)
+	return err
+}
+
+// HardResetToRemote hard-resets the working tree and local branch ref to
+// match origin/<branch>.
+func (r *shellRepository) HardResetToRemote(branch string) error {
+	_, err := r.run("reset", "--hard", "origin/"+branch)
+	return err
+}
+
+// fetchedRefName is where FetchRef parks whatever it fetches, since the ref
+// may not correspond to a normal local branch.
+const fetchedRefName = "refs/hooker/fetched-head"
+
+// FetchRef fetches an arbitrary ref from the "origin" remote and returns the
+// commit SHA it resolved to.
+func (r *shellRepository) FetchRef(ref string) (string, error) {
+	if _, err := r.run("fetch", "origin", "+"+ref+":"+fetchedRefName); err != nil {
+		return "", err
+	}
+	out, err := r.run("rev-parse", fetchedRefName)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// ResetToSHA hard-resets the working tree to the given commit.
+func (r *shellRepository) ResetToSHA(sha string) error {
+	_, err := r.run("reset", "--hard", sha)
+	return err
+}
+
+// CurrentSHA returns the commit SHA HEAD now points to.
+func (r *shellRepository) CurrentSHA() (string, error) {
+	out, err := r.run("rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// RemoteURL returns the URL configured for the "origin" remote.
+func (r *shellRepository) RemoteURL() (string, error) {
+	out, err := r.run("remote", "get-url", "origin")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
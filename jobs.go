@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// JobStatus is the current state of a queued or running job.
+type JobStatus string
+
+// Possible states of a Job.
+const (
+	JobPending JobStatus = "pending"
+	JobRunning JobStatus = "running"
+	JobSuccess JobStatus = "success"
+	JobFailed  JobStatus = "failed"
+)
+
+// Job represents a single webhook-triggered update of a repository. Once
+// created it is handed to a worker goroutine and, concurrently, to any
+// number of /jobs HTTP handlers. ID, Repo, Branch, BaseSHA, HeadRef and
+// CreatedAt are fixed at creation; every other field is only written by the
+// worker running the job, through the setter methods below, and must be read
+// by anyone else only through mu or the snapshot method.
+type Job struct {
+	mu *sync.Mutex `json:"-"`
+
+	ID      string `json:"id"`
+	Repo    string `json:"repo"`
+	Branch  string `json:"branch"`
+	BaseSHA string `json:"base_sha,omitempty"`
+	HeadSHA string `json:"head_sha,omitempty"`
+	// HeadRef, if set, is a ref fetchable from "origin" that resolves to
+	// HeadSHA, used for pull/merge requests whose head commit may live on a
+	// fork and so isn't already reachable by SHA alone.
+	HeadRef    string    `json:"head_ref,omitempty"`
+	Status     JobStatus `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Note       string    `json:"note,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	StartedAt  time.Time `json:"started_at,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+}
+
+// snapshot returns a point-in-time copy of job, safe to read or JSON-encode
+// without further synchronization.
+func (j *Job) snapshot() *Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	cp := *j
+	return &cp
+}
+
+// markRunning transitions job to JobRunning and records its start time.
+func (j *Job) markRunning() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Status = JobRunning
+	j.StartedAt = time.Now()
+}
+
+// markFinished records job's finish time.
+func (j *Job) markFinished() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.FinishedAt = time.Now()
+}
+
+// fail marks job as failed with the given error message.
+func (j *Job) fail(err error, msg string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Status = JobFailed
+	j.Error = msg + ": " + err.Error()
+}
+
+// succeed marks job as successful, with an optional note (e.g. explaining
+// that its pipeline was skipped).
+func (j *Job) succeed(note string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Status = JobSuccess
+	j.Note = note
+}
+
+// setHeadSHA updates job's head commit, e.g. once a pull request's head has
+// actually been resolved/fetched.
+func (j *Job) setHeadSHA(sha string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.HeadSHA = sha
+}
+
+// jobQueueSize bounds how many jobs may queue up per repository before
+// hooker starts rejecting webhooks for it.
+const jobQueueSize = 16
+
+// historySize is how many finished jobs are kept per repository in the
+// in-memory ring buffer.
+const historySize = 100
+
+var jobCounter uint64
+
+// newJobID returns a unique, monotonically distinguishable job ID.
+func newJobID() string {
+	return fmt.Sprintf("%d-%d", time.Now().UnixNano(), atomic.AddUint64(&jobCounter, 1))
+}
+
+// Dispatcher runs one worker goroutine per repository, so a slow job on one
+// repository never blocks a webhook for another. Within a repository, jobs
+// run one at a time in the order they were enqueued.
+type Dispatcher struct {
+	mu      sync.Mutex
+	queues  map[string]chan *Job
+	pending map[string]*Job // keyed by "repo@branch", the currently-queued job
+	jobs    map[string]*Job // keyed by job ID
+	history map[string][]*Job
+	store   *JobStore
+	run     func(*Job)
+}
+
+// NewDispatcher creates a Dispatcher that runs jobs with run and, if store
+// is non-nil, persists every job to it.
+func NewDispatcher(store *JobStore, run func(*Job)) *Dispatcher {
+	return &Dispatcher{
+		queues:  make(map[string]chan *Job),
+		pending: make(map[string]*Job),
+		jobs:    make(map[string]*Job),
+		history: make(map[string][]*Job),
+		store:   store,
+		run:     run,
+	}
+}
+
+// Enqueue queues a job to update repo to branch, recording the base/head
+// commits of the change if known (empty strings are fine, e.g. for a push
+// without a usable "before" commit) and, for a pull/merge request whose head
+// commit isn't already reachable from "origin" (e.g. it lives on a fork), a
+// headRef that can be fetched to get it. If a pending job already exists for
+// the same repo and branch, that job is returned instead of queueing a
+// duplicate.
+func (d *Dispatcher) Enqueue(repo, branch, baseSHA, headSHA, headRef string) (*Job, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := repo + "@" + branch
+	if job, ok := d.pending[key]; ok {
+		return job, nil
+	}
+
+	queue, ok := d.queues[repo]
+	if !ok {
+		queue = make(chan *Job, jobQueueSize)
+		d.queues[repo] = queue
+		go d.worker(repo, queue)
+	}
+
+	job := &Job{
+		mu:        &sync.Mutex{},
+		ID:        newJobID(),
+		Repo:      repo,
+		Branch:    branch,
+		BaseSHA:   baseSHA,
+		HeadSHA:   headSHA,
+		HeadRef:   headRef,
+		Status:    JobPending,
+		CreatedAt: time.Now(),
+	}
+
+	select {
+	case queue <- job:
+	default:
+		return nil, fmt.Errorf("job queue full for repository '%s'", repo)
+	}
+
+	d.pending[key] = job
+	d.jobs[job.ID] = job
+	d.save(job)
+	queueDepth.WithLabelValues(repo).Inc()
+
+	return job, nil
+}
+
+// worker processes jobs for a single repository, one at a time.
+func (d *Dispatcher) worker(repo string, queue chan *Job) {
+	for job := range queue {
+		d.mu.Lock()
+		delete(d.pending, job.Repo+"@"+job.Branch)
+		d.mu.Unlock()
+		job.markRunning()
+		d.save(job)
+
+		d.run(job)
+
+		job.markFinished()
+		d.mu.Lock()
+		d.history[repo] = append(d.history[repo], job)
+		if len(d.history[repo]) > historySize {
+			d.history[repo] = d.history[repo][len(d.history[repo])-historySize:]
+		}
+		d.mu.Unlock()
+		d.save(job)
+		queueDepth.WithLabelValues(repo).Dec()
+	}
+}
+
+func (d *Dispatcher) save(job *Job) {
+	if d.store == nil {
+		return
+	}
+	if err := d.store.Save(job.snapshot()); err != nil {
+		logger.Error().Str("repo", job.Repo).Str("job_id", job.ID).Err(err).Msg("could not persist job")
+	}
+}
+
+// Get looks up a job by ID, returning a snapshot safe for the caller to read
+// or encode without further synchronization.
+func (d *Dispatcher) Get(id string) (*Job, bool) {
+	d.mu.Lock()
+	job, ok := d.jobs[id]
+	d.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	return job.snapshot(), true
+}
+
+// History returns the most recent finished jobs for a repository, oldest
+// first, as snapshots safe for the caller to read or encode without further
+// synchronization.
+func (d *Dispatcher) History(repo string) []*Job {
+	d.mu.Lock()
+	jobs := append([]*Job(nil), d.history[repo]...)
+	d.mu.Unlock()
+
+	snapshots := make([]*Job, len(jobs))
+	for i, job := range jobs {
+		snapshots[i] = job.snapshot()
+	}
+	return snapshots
+}
+
+// handleJobStatus serves GET /jobs/:id with the current state of a job.
+func handleJobStatus(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	job, ok := dispatcher.Get(ps.ByName("id"))
+	if !ok {
+		http.Error(w, "404", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// handleJobHistory serves GET /jobs?repo=group/project with the recent job
+// history for a repository.
+func handleJobHistory(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	repo := r.URL.Query().Get("repo")
+	if repo == "" {
+		http.Error(w, "400: 'repo' query parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dispatcher.History(repo))
+}
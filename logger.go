@@ -0,0 +1,11 @@
+package main
+
+import (
+	"os"
+
+	"github.com/rs/zerolog"
+)
+
+// logger is hooker's structured logger. Call-sites attach repo/ref/provider
+// context with .With()/.Str() rather than formatting it into the message.
+var logger = zerolog.New(os.Stdout).With().Timestamp().Logger()
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// handleLogs serves the rolling pipeline log for a repository branch, e.g.
+// GET /logs/group/project/main. The trailing path segment is taken as the
+// branch; everything before it is the repo key. Falls back to "master" if
+// the repo has no branches configured.
+func handleLogs(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	rest := strings.TrimPrefix(r.URL.Path, "/logs/")
+
+	repoKey, branch := rest, "master"
+	if idx := strings.LastIndex(rest, "/"); idx != -1 {
+		if rc, ok := c.Repos[rest[:idx]]; ok && allowedBranch(rc, rest[idx+1:]) {
+			repoKey, branch = rest[:idx], rest[idx+1:]
+		}
+	}
+
+	if _, ok := c.Repos[repoKey]; !ok {
+		http.Error(w, "404", http.StatusNotFound)
+		return
+	}
+
+	http.ServeFile(w, r, logPath(repoKey, branch))
+}
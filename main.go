@@ -6,15 +6,10 @@ import (
 	"fmt"
 	"github.com/BurntSushi/toml"
 	"github.com/julienschmidt/httprouter"
-	"gopkg.in/libgit2/git2go.v22"
-	"io"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"io/ioutil"
-	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
-	"sync"
 )
 
 var (
@@ -23,13 +18,25 @@ var (
 
 // Config for our lovely hooker
 type Config struct {
-	HookPath string
-	Host     string
-	Port     uint16
+	HookPath   string
+	Host       string
+	Port       uint16
+	JobDBPath  string                `toml:"job_db_path"`
+	GitBackend string                `toml:"git_backend"`
+	Repos      map[string]RepoConfig `toml:"repos"`
+}
+
+// RepoConfig holds per-repository settings, keyed by the path hooker
+// receives the webhook on (e.g. "group/project").
+type RepoConfig struct {
+	Secret   string   `toml:"secret"`
+	Steps    []Step   `toml:"steps"`
+	Branches []string `toml:"branches"`
+	Paths    []string `toml:"paths"`
 }
 
 var c Config
-var mutex = &sync.Mutex{}
+var dispatcher *Dispatcher
 
 // Ref Interface to support different webhooks
 type Ref interface {
@@ -39,168 +46,181 @@ type Ref interface {
 // BitbucketServerWebhook stripped down to the bare minimum
 type BitbucketServerWebhook struct {
 	RefChanges []struct {
-		RefID string `json:"refId"`
+		RefID    string `json:"refId"`
+		FromHash string `json:"fromHash"`
+		ToHash   string `json:"toHash"`
 	} `json:"refChanges"`
 }
 
 // GitLabWebhook, also just the bare minimum
 type GitLabWebhook struct {
-	ref string `json:"ref"`
+	RefName string `json:"ref"`
+	Before  string `json:"before"`
+	After   string `json:"after"`
 }
 
-// Ref Returns the Ref of the Change
+// Ref returns the first ref change's ref, e.g. "refs/heads/main". Bitbucket
+// Server can bundle several ref changes into one delivery; callers that need
+// all of them should use Refs() instead.
 func (b BitbucketServerWebhook) Ref() string {
-	for _, r := range b.RefChanges {
-		if r.RefID == "refs/heads/master" {
-			return "refs/heads/master"
-		}
+	if len(b.RefChanges) == 0 {
+		return ""
 	}
-	return "not master"
-}
-
-// Ref returns the Ref of the change
-func (w GitLabWebhook) Ref() string {
-	return w.ref
+	return b.RefChanges[0].RefID
 }
 
-func unmarshalPayload(r io.Reader) (Ref, error) {
-	data, err := ioutil.ReadAll(r)
-	if err != nil {
-		return nil, err
-	}
-
-	services := []interface{}{&BitbucketServerWebhook{}, &GitLabWebhook{}}
-	for _, s := range services {
-		if err := json.Unmarshal(data, &s); err == nil {
-			return s.(Ref), nil
-		}
+// Changes returns the before/after commits of the first ref change.
+func (b BitbucketServerWebhook) Changes() (string, string) {
+	if len(b.RefChanges) == 0 {
+		return "", ""
 	}
-	return nil, err
+	return b.RefChanges[0].FromHash, b.RefChanges[0].ToHash
 }
 
-func handleWebhook(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	ref, err := unmarshalPayload(r.Body)
-
-	http500 := func(msg string) {
-		log.Println(msg)
-		http.Error(w, "500", http.StatusInternalServerError)
-	}
-
-	if err != nil {
-		http500(fmt.Sprintf("invalid payload: %s", err))
-		return
-	}
-
-	if ref.Ref() != "refs/heads/master" {
-		http500(fmt.Sprintf("ignoring changeset on '%s', not a change on master\n", r.URL.Path))
-		return
+// Refs returns every ref change carried by this payload, implementing
+// MultiRef.
+func (b BitbucketServerWebhook) Refs() []RefChange {
+	changes := make([]RefChange, len(b.RefChanges))
+	for i, rc := range b.RefChanges {
+		changes[i] = RefChange{Ref: rc.RefID, BaseSHA: rc.FromHash, HeadSHA: rc.ToHash}
 	}
+	return changes
+}
 
-	log.Printf("updating repository '%s'\n", filepath.Join(c.HookPath, r.URL.Path))
-	mutex.Lock()
-	defer mutex.Unlock()
+// Ref returns the ref of the change, e.g. "refs/heads/main"
+func (w GitLabWebhook) Ref() string {
+	return w.RefName
+}
 
-	f, err := os.Stat(filepath.Join(c.HookPath, r.URL.Path))
-	if err != nil {
-		log.Printf("invalid repository '%s': %s\n", filepath.Join(c.HookPath, r.URL.Path), err)
-		http.Error(w, "404", http.StatusNotFound)
-		return
-	}
+// Changes returns the before/after commits of the push.
+func (w GitLabWebhook) Changes() (string, string) {
+	return w.Before, w.After
+}
 
-	if !f.IsDir() {
-		log.Printf("not a directory: '%s'\n", filepath.Join(c.HookPath, r.URL.Path))
-		http.Error(w, "404", http.StatusNotFound)
-		return
+// unmarshalPayload picks the right payload struct for the provider and
+// event type a webhook was sent with, and decodes data into it.
+func unmarshalPayload(provider Provider, event string, data []byte) (Ref, error) {
+	var target Ref
+
+	switch provider {
+	case ProviderGitHub:
+		if event == "pull_request" {
+			target = &GitHubPullRequestWebhook{}
+		} else {
+			target = &GitHubPushWebhook{}
+		}
+	case ProviderGitLab:
+		if event == "Merge Request Hook" {
+			target = &GitLabMergeRequestWebhook{}
+		} else {
+			target = &GitLabWebhook{}
+		}
+	case ProviderBitbucket:
+		if event == "pr:opened" || event == "pr:merged" {
+			target = &BitbucketPullRequestWebhook{}
+		} else {
+			target = &BitbucketServerWebhook{}
+		}
+	default:
+		return nil, fmt.Errorf("unknown provider")
 	}
 
-	rdir := filepath.Join(c.HookPath, r.URL.Path, ".git")
-
-	f, err = os.Stat(rdir)
-	if err != nil {
-		log.Println("not a git repository")
-		http.Error(w, "403", http.StatusForbidden)
-		return
+	if err := json.Unmarshal(data, target); err != nil {
+		return nil, err
 	}
+	return target, nil
+}
 
-	if !f.IsDir() {
-		log.Println(".git a file, not a repository")
-		http.Error(w, "403", http.StatusForbidden)
-		return
+func handleWebhook(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	repoKey := strings.TrimPrefix(r.URL.Path, "/")
+	provider := detectProvider(r)
+	log := logger.With().Str("repo", repoKey).Str("provider", provider.String()).Logger()
+
+	// metricRepo is only set to the real repoKey once it's confirmed to be a
+	// configured repository, so hitting arbitrary paths can't grow
+	// webhookRequestsTotal's repo label without bound.
+	metricRepo := "unknown"
+
+	fail := func(status int, result string, err error, msg string) {
+		webhookRequestsTotal.WithLabelValues(provider.String(), metricRepo, result).Inc()
+		ev := log.Error()
+		if err != nil {
+			ev = ev.Err(err)
+		}
+		ev.Msg(msg)
+		http.Error(w, fmt.Sprintf("%d", status), status)
 	}
 
-	repo, err := git.OpenRepositoryExtended(rdir)
+	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		http500("could not open git repository")
+		fail(http.StatusInternalServerError, "error", err, "could not read request body")
 		return
 	}
 
-	remote, err := repo.LookupRemote("origin")
-	if err != nil {
-		http500("could not lookup remote 'origin'")
+	repoConfig, ok := c.Repos[repoKey]
+	if !ok || repoConfig.Secret == "" {
+		fail(http.StatusUnauthorized, "unauthorized", nil, "no secret configured, rejecting")
 		return
 	}
+	metricRepo = repoKey
 
-	if err := remote.Fetch(nil, nil, ""); err != nil {
-		http500(fmt.Sprintf("could not fetch 'origin': %s", err))
+	if !verifySignature(provider, r, body, repoConfig.Secret) {
+		fail(http.StatusUnauthorized, "unauthorized", nil, "invalid or missing signature")
 		return
 	}
 
-	remoteRef, err := repo.LookupReference("refs/remotes/origin/master")
+	event, err := unmarshalPayload(provider, eventType(provider, r), body)
 	if err != nil {
-		http500(fmt.Sprintf("could not lookup 'refs/remotes/origin/master': %s", err))
+		fail(http.StatusInternalServerError, "error", err, "invalid payload")
 		return
 	}
-	remoteTarget := remoteRef.Target()
 
-	remHead, err := repo.AnnotatedCommitFromRef(remoteRef)
-	if err != nil {
-		http500(fmt.Sprintf("could not get commit from ref: %s", err))
-		return
+	var changes []RefChange
+	if multi, ok := event.(MultiRef); ok {
+		changes = multi.Refs()
+	} else {
+		var base, head string
+		if chg, ok := event.(Changes); ok {
+			base, head = chg.Changes()
+		}
+		var headRef string
+		if pr, ok := event.(PullRequestRef); ok {
+			headRef = pr.HeadRef()
+		}
+		changes = []RefChange{{Ref: event.Ref(), BaseSHA: base, HeadSHA: head, HeadRef: headRef}}
 	}
 
-	if err := repo.Merge([]*git.AnnotatedCommit{remHead}, nil, nil); err != nil {
-		http500(fmt.Sprintf("could not merge origin/master into local repo: %s", err))
-		return
-	}
+	var jobs []*Job
+	for _, change := range changes {
+		branch := branchFromRef(change.Ref)
+		if branch == "" || !allowedBranch(repoConfig, branch) {
+			continue
+		}
 
-	// Point local brancht at remote
-	remCommit, err := repo.LookupCommit(remoteTarget)
-	if err != nil {
-		http500(fmt.Sprintf("could not lookup commit on remote: %s", err))
-		return
-	}
+		job, err := dispatcher.Enqueue(repoKey, branch, change.BaseSHA, change.HeadSHA, change.HeadRef)
+		if err != nil {
+			fail(http.StatusInternalServerError, "error", err, "could not enqueue job")
+			return
+		}
 
-	remTree, err := remCommit.Tree()
-	if err != nil {
-		http500(fmt.Sprintf("could not lookup remote tree: %s", err))
-		return
+		log.Info().Str("branch", branch).Str("job_id", job.ID).Msg("queued job")
+		jobs = append(jobs, job.snapshot())
 	}
 
-	if err := repo.CheckoutTree(remTree, &git.CheckoutOpts{Strategy: git.CheckoutForce}); err != nil {
-		http500(fmt.Sprintf("could not checkout remote tree: %s", err))
+	if len(jobs) == 0 {
+		fail(http.StatusInternalServerError, "ignored", nil, "ignoring changeset, not a configured branch")
 		return
 	}
 
-	head, err := repo.Head()
-	if err != nil {
-		http500(fmt.Sprintf("could not get head: %s", err))
-		return
-	}
+	webhookRequestsTotal.WithLabelValues(provider.String(), metricRepo, "accepted").Inc()
 
-	localBranch, err := repo.LookupReference("refs/heads/master")
-	if err != nil {
-		http500(fmt.Sprintf("could not lookup local master: %s", err))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if len(jobs) == 1 {
+		json.NewEncoder(w).Encode(jobs[0])
 		return
 	}
-
-	localBranch.SetTarget(remoteTarget, nil, "")
-	head.SetTarget(remoteTarget, nil, "")
-
-	repo.StateCleanup()
-
-	log.Printf("repository '%s' updated.\n", filepath.Join(c.HookPath, r.URL.Path))
-	w.WriteHeader(200)
-	w.Write([]byte("ok"))
+	json.NewEncoder(w).Encode(jobs)
 }
 
 func main() {
@@ -233,15 +253,30 @@ func main() {
 	fmt.Println("                  hooker - bitbucket webhook deployment")
 	fmt.Println()
 
-	log.Println("loading config file")
+	logger.Info().Str("file", *configFile).Msg("loading config file")
 	if _, err := toml.DecodeFile(*configFile, &c); err != nil {
-		log.Fatal(err)
+		logger.Fatal().Err(err).Msg("could not load config file")
+	}
+
+	var store *JobStore
+	if c.JobDBPath != "" {
+		var err error
+		store, err = openJobStore(c.JobDBPath)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("could not open job store")
+		}
 	}
+	dispatcher = NewDispatcher(store, processRepoUpdate)
 
 	r := httprouter.New()
 	r.POST("/*rest", handleWebhook)
+	r.GET("/logs/*rest", handleLogs)
+	r.GET("/jobs/:id", handleJobStatus)
+	r.GET("/jobs", handleJobHistory)
+	r.Handler("GET", "/metrics", promhttp.Handler())
 
 	http.Handle("/", r)
-	log.Println("starting server on", fmt.Sprintf("%s:%d", c.Host, c.Port))
-	log.Fatal(http.ListenAndServe(fmt.Sprintf("%s:%d", c.Host, c.Port), nil))
+	addr := fmt.Sprintf("%s:%d", c.Host, c.Port)
+	logger.Info().Str("addr", addr).Msg("starting server")
+	logger.Fatal().Err(http.ListenAndServe(addr, nil)).Msg("server exited")
 }
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	webhookRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "hooker_webhook_requests_total",
+		Help: "Total webhook requests received, by provider, repo and result.",
+	}, []string{"provider", "repo", "result"})
+
+	fetchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "hooker_fetch_duration_seconds",
+		Help: "Time spent fetching a repository from its remote.",
+	}, []string{"repo"})
+
+	deployDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "hooker_deploy_duration_seconds",
+		Help: "Time spent on a repository's deploy job, from checkout through its pipeline, by result.",
+	}, []string{"repo", "status"})
+
+	queueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "hooker_queue_depth",
+		Help: "Number of jobs currently queued or running for a repository.",
+	}, []string{"repo"})
+
+	lastDeployTimestamp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "hooker_last_deploy_timestamp",
+		Help: "Unix timestamp of the last completed deploy for a repository.",
+	}, []string{"repo"})
+)
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// changedFiles returns the files that differ between base and head in
+// repoPath, using the provider's commit SHAs. It returns nil if either SHA
+// is unknown (empty or all zeros), which callers treat as "can't tell,
+// don't filter"; a known but empty diff returns an empty, non-nil slice.
+func changedFiles(repoPath, base, head string) ([]string, error) {
+	if strings.Trim(base, "0") == "" || strings.Trim(head, "0") == "" {
+		return nil, nil
+	}
+
+	cmd := exec.Command("git", "diff", "--name-only", base+".."+head)
+	cmd.Dir = repoPath
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	for _, f := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// matchesPath reports whether file matches a configured path pattern. A
+// pattern ending in "/**" matches everything under that directory;
+// otherwise the pattern is matched with filepath.Match.
+func matchesPath(pattern, file string) bool {
+	if strings.HasSuffix(pattern, "/**") {
+		return strings.HasPrefix(file, strings.TrimSuffix(pattern, "**"))
+	}
+	ok, _ := filepath.Match(pattern, file)
+	return ok
+}
+
+// pathsMatch reports whether any of files matches any of patterns. An empty
+// patterns list means no path filter is configured, so everything matches.
+func pathsMatch(patterns, files []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, f := range files {
+		for _, p := range patterns {
+			if matchesPath(p, f) {
+				return true
+			}
+		}
+	}
+	return false
+}
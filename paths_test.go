@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestMatchesPath(t *testing.T) {
+	tests := []struct {
+		pattern, file string
+		want          bool
+	}{
+		{"src/**", "src/main.go", true},
+		{"src/**", "src/sub/dir/file.go", true},
+		{"src/**", "other/main.go", false},
+		{"*.go", "main.go", true},
+		{"*.go", "main.js", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchesPath(tt.pattern, tt.file); got != tt.want {
+			t.Errorf("matchesPath(%q, %q) = %v, want %v", tt.pattern, tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestPathsMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		files    []string
+		want     bool
+	}{
+		{"no patterns matches anything", nil, []string{"main.go"}, true},
+		{"matching file", []string{"src/**"}, []string{"src/main.go"}, true},
+		{"no matching file", []string{"src/**"}, []string{"docs/readme.md"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pathsMatch(tt.patterns, tt.files); got != tt.want {
+				t.Errorf("pathsMatch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
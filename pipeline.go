@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// maxLogSize is the size at which a repository's log file is rotated.
+const maxLogSize = 10 * 1024 * 1024 // 10MB
+
+// logDir is the subdirectory of HookPath that rolling pipeline logs are
+// written to.
+const logDir = ".hooker-logs"
+
+// Step is a single pipeline step, run as a shell command after a successful
+// fetch and checkout. It is configured per repository in the TOML config,
+// e.g. `[repos."x/y"] steps = [...]`. If Image is set, commands run inside
+// that container via `docker run` instead of directly on the host.
+type Step struct {
+	Image       string            `toml:"image"`
+	Commands    []string          `toml:"commands"`
+	When        StepWhen          `toml:"when"`
+	Environment map[string]string `toml:"environment"`
+}
+
+// StepWhen filters whether a step runs for the branch that was just checked
+// out. An empty Branch matches every branch.
+type StepWhen struct {
+	Branch string `toml:"branch"`
+}
+
+// logPath returns the rolling log file for a branch of a repository,
+// identified by the path it was registered under in the config (e.g.
+// "group/project") and the branch that was deployed.
+func logPath(repoKey, branch string) string {
+	return filepath.Join(c.HookPath, logDir, repoKey, branch+".log")
+}
+
+// openRepoLog opens the rolling log file for appending, rotating it first if
+// it has grown past maxLogSize.
+func openRepoLog(repoKey, branch string) (*os.File, error) {
+	path := logPath(repoKey, branch)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	if fi, err := os.Stat(path); err == nil && fi.Size() > maxLogSize {
+		os.Rename(path, path+".1")
+	}
+
+	return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+}
+
+// runPipeline runs each configured step's commands in repoPath, skipping
+// steps whose When.Branch doesn't match the branch that was just checked
+// out. A step with an Image runs its commands inside that container, with
+// repoPath bind-mounted as the working directory; otherwise commands run
+// directly on the host. Output from every command is streamed to w.
+func runPipeline(repoPath, branch string, steps []Step, w io.Writer) error {
+	for _, step := range steps {
+		if step.When.Branch != "" && step.When.Branch != branch {
+			continue
+		}
+
+		for _, command := range step.Commands {
+			fmt.Fprintf(w, "+ %s\n", command)
+
+			var cmd *exec.Cmd
+			if step.Image != "" {
+				cmd = exec.Command("docker", containerArgs(repoPath, step, command)...)
+			} else {
+				cmd = exec.Command("sh", "-c", command)
+				cmd.Dir = repoPath
+				cmd.Env = os.Environ()
+				for k, v := range step.Environment {
+					cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+				}
+			}
+			cmd.Stdout = w
+			cmd.Stderr = w
+
+			if err := cmd.Run(); err != nil {
+				fmt.Fprintf(w, "step failed: %s\n", err)
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// containerArgs builds the `docker run` arguments to execute command inside
+// step.Image, with repoPath bind-mounted as /workspace and used as the
+// working directory.
+func containerArgs(repoPath string, step Step, command string) []string {
+	args := []string{"run", "--rm", "-v", repoPath + ":/workspace", "-w", "/workspace"}
+	for k, v := range step.Environment {
+		args = append(args, "-e", fmt.Sprintf("%s=%s", k, v))
+	}
+	return append(args, step.Image, "sh", "-c", command)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func hmacSHA256Sig(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyGitHubSignature(t *testing.T) {
+	secret := "s3cr3t"
+	body := []byte(`{"ref":"refs/heads/main"}`)
+
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{"valid signature", hmacSHA256Sig(secret, body), true},
+		{"wrong secret", hmacSHA256Sig("wrong", body), false},
+		{"missing header", "", false},
+		{"malformed prefix", "not-a-valid-sig", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/repo", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Hub-Signature-256", tt.header)
+			}
+
+			if got := verifyGitHubSignature(r, body, secret); got != tt.want {
+				t.Errorf("verifyGitHubSignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyGitLabToken(t *testing.T) {
+	secret := "s3cr3t"
+
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{"valid token", secret, true},
+		{"wrong secret", "wrong", false},
+		{"missing header", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/repo", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Gitlab-Token", tt.header)
+			}
+
+			if got := verifyGitLabToken(r, secret); got != tt.want {
+				t.Errorf("verifyGitLabToken() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyBitbucketSignature(t *testing.T) {
+	secret := "s3cr3t"
+	body := []byte(`{"refChanges":[{"refId":"refs/heads/main"}]}`)
+
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{"valid signature", hmacSHA256Sig(secret, body), true},
+		{"wrong secret", hmacSHA256Sig("wrong", body), false},
+		{"missing header", "", false},
+		{"malformed prefix", "not-a-valid-sig", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/repo", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Hub-Signature", tt.header)
+			}
+
+			if got := verifyBitbucketSignature(r, body, secret); got != tt.want {
+				t.Errorf("verifyBitbucketSignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+)
+
+var jobsBucket = []byte("jobs")
+
+// JobStore persists jobs to a BoltDB file so job history survives restarts.
+// It is optional: hooker works fine with in-memory-only history if
+// JobDBPath isn't configured.
+type JobStore struct {
+	db *bolt.DB
+}
+
+// openJobStore opens (creating if necessary) the BoltDB file at path.
+func openJobStore(path string) (*JobStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(jobsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &JobStore{db: db}, nil
+}
+
+// Save persists a job, keyed by its ID.
+func (s *JobStore) Save(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put([]byte(job.ID), data)
+	})
+}
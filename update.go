@@ -0,0 +1,170 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Rukenshia/hooker/gitbackend"
+)
+
+// backend returns the configured gitbackend.Backend, defaulting to the
+// pure-Go implementation when git_backend isn't set.
+func backend() gitbackend.Backend {
+	if c.GitBackend == "shell" {
+		return gitbackend.Shell{}
+	}
+	return gitbackend.GoGit{}
+}
+
+// processRepoUpdate fetches and hard-resets the branch checkout identified
+// by job.Repo/job.Branch to origin/<branch>, then runs the configured
+// pipeline. A pull/merge request (job.HeadRef set) never touches that
+// checkout: it gets its own directory, provisioned on demand from the
+// branch's origin remote, so building unreviewed code can never overwrite a
+// production deployment. It is the function a Dispatcher worker calls for
+// every job; it never touches an HTTP response, only the job itself, since
+// by the time it runs the webhook request has already been answered with a
+// 202.
+func processRepoUpdate(job *Job) {
+	log := logger.With().Str("repo", job.Repo).Str("branch", job.Branch).Logger()
+	deployStart := time.Now()
+
+	fail := func(err error, msg string) {
+		log.Error().Err(err).Msg(msg)
+		job.fail(err, msg)
+		deployDuration.WithLabelValues(job.Repo, string(JobFailed)).Observe(time.Since(deployStart).Seconds())
+	}
+
+	be := backend()
+	branchPath := checkoutDir(job.Repo, job.Branch)
+	repoPath := branchPath
+	logLabel := job.Branch
+	if job.HeadRef != "" {
+		repoPath = prCheckoutDir(job.Repo, job.HeadRef)
+		logLabel = filepath.Base(repoPath)
+		log = log.With().Str("pr_checkout", repoPath).Logger()
+
+		if _, err := os.Stat(repoPath); os.IsNotExist(err) {
+			branchRepo, err := be.Open(branchPath)
+			if err != nil {
+				fail(err, "could not open branch repository to provision pull/merge request checkout")
+				return
+			}
+
+			url, err := branchRepo.RemoteURL()
+			if err != nil {
+				fail(err, "could not determine origin URL")
+				return
+			}
+
+			if err := be.Clone(url, repoPath); err != nil {
+				fail(err, "could not provision pull/merge request checkout")
+				return
+			}
+		}
+	}
+
+	f, err := os.Stat(repoPath)
+	if err != nil {
+		fail(err, "invalid repository")
+		return
+	}
+
+	if !f.IsDir() {
+		fail(os.ErrInvalid, "not a directory: "+repoPath)
+		return
+	}
+
+	f, err = os.Stat(filepath.Join(repoPath, ".git"))
+	if err != nil {
+		fail(err, "not a git repository")
+		return
+	}
+
+	if !f.IsDir() {
+		fail(os.ErrInvalid, ".git a file, not a repository: "+repoPath)
+		return
+	}
+
+	repo, err := be.Open(repoPath)
+	if err != nil {
+		fail(err, "could not open git repository")
+		return
+	}
+
+	fetchStart := time.Now()
+	err = repo.Fetch(job.Branch)
+	fetchDuration.WithLabelValues(job.Repo).Observe(time.Since(fetchStart).Seconds())
+	if err != nil {
+		fail(err, "could not fetch 'origin'")
+		return
+	}
+
+	if job.HeadRef != "" {
+		// This is a pull/merge request: its head commit may live on a fork
+		// and so isn't necessarily reachable by fetching job.Branch (the
+		// PR's base branch) alone. Fetch it explicitly and build that
+		// commit, so the pipeline actually runs the change being proposed
+		// rather than the unchanged base branch.
+		headSHA, err := repo.FetchRef(job.HeadRef)
+		if err != nil {
+			fail(err, "could not fetch pull/merge request head "+job.HeadRef)
+			return
+		}
+		if job.HeadSHA == "" {
+			job.setHeadSHA(headSHA)
+		}
+
+		if err := repo.ResetToSHA(headSHA); err != nil {
+			fail(err, "could not reset to "+headSHA)
+			return
+		}
+	} else if err := repo.HardResetToRemote(job.Branch); err != nil {
+		fail(err, "could not reset to origin/"+job.Branch)
+		return
+	}
+
+	sha, err := repo.CurrentSHA()
+	if err != nil {
+		fail(err, "could not determine current commit")
+		return
+	}
+	log = log.With().Str("commit_sha", sha).Logger()
+
+	log.Info().Msg("repository updated")
+
+	repoConfig := c.Repos[job.Repo]
+	if len(repoConfig.Paths) > 0 {
+		files, err := changedFiles(repoPath, job.BaseSHA, job.HeadSHA)
+		if err != nil {
+			fail(err, "could not determine changed files")
+			return
+		}
+
+		if files != nil && !pathsMatch(repoConfig.Paths, files) {
+			note := "no changed files matched configured paths; pipeline skipped"
+			job.succeed(note)
+			log.Info().Msg(note)
+			deployDuration.WithLabelValues(job.Repo, string(JobSuccess)).Observe(time.Since(deployStart).Seconds())
+			lastDeployTimestamp.WithLabelValues(job.Repo).Set(float64(time.Now().Unix()))
+			return
+		}
+	}
+
+	repoLog, err := openRepoLog(job.Repo, logLabel)
+	if err != nil {
+		fail(err, "could not open repository log")
+		return
+	}
+	defer repoLog.Close()
+
+	if err := runPipeline(repoPath, job.Branch, repoConfig.Steps, repoLog); err != nil {
+		fail(err, "pipeline failed")
+		return
+	}
+
+	job.succeed("")
+	deployDuration.WithLabelValues(job.Repo, string(JobSuccess)).Observe(time.Since(deployStart).Seconds())
+	lastDeployTimestamp.WithLabelValues(job.Repo).Set(float64(time.Now().Unix()))
+}